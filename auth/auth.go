@@ -0,0 +1,66 @@
+// Package auth issues and validates the short-lived JWTs that authenticate
+// a WebSocket connection for the chat service.
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidToken is returned by Parse for a token that is missing,
+// malformed, expired, or signed with an unexpected algorithm.
+var ErrInvalidToken = errors.New("invalid token")
+
+type claims struct {
+	jwt.RegisteredClaims
+}
+
+// Issuer mints and validates HS256 JWTs whose subject is a user ID.
+type Issuer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewIssuer returns an Issuer signing tokens with secret and expiring them
+// after ttl.
+func NewIssuer(secret []byte, ttl time.Duration) *Issuer {
+	return &Issuer{secret: secret, ttl: ttl}
+}
+
+// Issue returns a signed JWT whose subject is userID, valid for the
+// Issuer's configured ttl.
+func (i *Issuer) Issue(userID string) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(i.ttl)),
+		},
+	})
+	return token.SignedString(i.secret)
+}
+
+// Parse validates tokenString and returns the authenticated user ID, or
+// ErrInvalidToken if the token is missing, malformed, expired, or was
+// signed with a different secret or algorithm.
+func (i *Issuer) Parse(tokenString string) (string, error) {
+	if tokenString == "" {
+		return "", ErrInvalidToken
+	}
+
+	var c claims
+	token, err := jwt.ParseWithClaims(tokenString, &c, func(t *jwt.Token) (interface{}, error) {
+		if t.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+			return nil, ErrInvalidToken
+		}
+		return i.secret, nil
+	})
+	if err != nil || !token.Valid || c.Subject == "" {
+		return "", ErrInvalidToken
+	}
+
+	return c.Subject, nil
+}
@@ -0,0 +1,86 @@
+// Package broker lets multiple instances of the chat server share delivery
+// of messages to users who may be connected to a different pod than the
+// one that sent the message.
+package broker
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Broker fans a message addressed to userID out to whichever instance (if
+// any) currently owns that user's WebSocket connection.
+type Broker interface {
+	// Publish announces message for userID to every instance subscribed
+	// to that user. delivered reports whether at least one instance was
+	// actually subscribed to receive it, so callers can decide whether a
+	// durable fallback (e.g. an offline queue) is needed.
+	Publish(ctx context.Context, userID string, message []byte) (delivered bool, err error)
+	// Subscribe relays messages published for userID to deliver until ctx
+	// is cancelled. Callers should invoke this once per locally-connected
+	// user, for as long as that user stays connected to this instance.
+	Subscribe(ctx context.Context, userID string, deliver func([]byte)) error
+}
+
+// Local is a no-op Broker for single-instance deployments: Publish never
+// has a remote subscriber to report and Subscribe simply blocks until ctx
+// is cancelled, since a single instance never needs to hear about its own
+// local deliveries from anywhere else.
+type Local struct{}
+
+// Publish implements Broker.
+func (Local) Publish(ctx context.Context, userID string, message []byte) (bool, error) {
+	return false, nil
+}
+
+// Subscribe implements Broker.
+func (Local) Subscribe(ctx context.Context, userID string, deliver func([]byte)) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// channelFor returns the Redis pub/sub channel a user's messages are
+// published on.
+func channelFor(userID string) string {
+	return "chat:user:" + userID
+}
+
+// Redis is a Broker backed by Redis pub/sub, letting any number of server
+// instances share delivery to users connected to a different instance.
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis returns a Redis broker using client.
+func NewRedis(client *redis.Client) *Redis {
+	return &Redis{client: client}
+}
+
+// Publish implements Broker.
+func (b *Redis) Publish(ctx context.Context, userID string, message []byte) (bool, error) {
+	receivers, err := b.client.Publish(ctx, channelFor(userID), message).Result()
+	if err != nil {
+		return false, err
+	}
+	return receivers > 0, nil
+}
+
+// Subscribe implements Broker.
+func (b *Redis) Subscribe(ctx context.Context, userID string, deliver func([]byte)) error {
+	pubsub := b.client.Subscribe(ctx, channelFor(userID))
+	defer pubsub.Close()
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			deliver([]byte(msg.Payload))
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLocalPublishReportsNoDelivery(t *testing.T) {
+	var b Local
+
+	delivered, err := b.Publish(context.Background(), "alice", []byte("hi"))
+	if err != nil {
+		t.Fatalf("Publish returned error: %v", err)
+	}
+	if delivered {
+		t.Fatal("Local.Publish reported delivered = true, want false")
+	}
+}
+
+func TestLocalSubscribeBlocksUntilCancelled(t *testing.T) {
+	var b Local
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Subscribe(ctx, "alice", func([]byte) {})
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Subscribe returned early with %v, want to block until cancelled", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("Subscribe returned %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Subscribe did not return after ctx was cancelled")
+	}
+}
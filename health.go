@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// handleHealthz reports whether the process is up, for k8s liveness
+// probes.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleReadyz reports whether the process can serve traffic, for k8s
+// readiness probes. It pings MongoDB since the server can't save or
+// replay messages without it.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	if err := mongoClient.Ping(ctx, nil); err != nil {
+		logger.Error("readyz: MongoDB ping failed", "error", err)
+		http.Error(w, "mongo unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,219 @@
+// Package hub implements a small single-goroutine pub/sub core for the chat
+// server: client registration, topic subscriptions, and fan-out broadcast.
+// Centralizing all of that state behind one goroutine (Hub.Run) removes the
+// need for a mutex around connection/topic maps shared across reader and
+// writer goroutines.
+package hub
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// personalTopicPrefix namespaces the topic every client is implicitly
+// subscribed to for direct messages, keeping it out of the room-topic
+// namespace a client can Subscribe to by name. Without this split, a
+// client could Subscribe to another user's bare ID and eavesdrop on their
+// direct messages.
+const personalTopicPrefix = "user:"
+
+// ErrReservedTopic is returned by Subscribe for a topic in the reserved
+// personal-message namespace; only Register subscribes a client there.
+// Publish does not check the namespace, so callers relaying client frames
+// reject reserved topics themselves with IsPersonalTopic.
+var ErrReservedTopic = errors.New("hub: topic is reserved for personal messages")
+
+// PersonalTopic returns the topic a user's direct messages are published
+// and implicitly subscribed to.
+func PersonalTopic(id string) string {
+	return personalTopicPrefix + id
+}
+
+// IsPersonalTopic reports whether topic is in the reserved personal-
+// message namespace, for callers that need to reject it (e.g. a client
+// trying to broadcast into another user's personal topic) before it ever
+// reaches Subscribe or Publish.
+func IsPersonalTopic(topic string) bool {
+	return isReserved(topic)
+}
+
+func isReserved(topic string) bool {
+	return strings.HasPrefix(topic, personalTopicPrefix)
+}
+
+// Client is the hub-side handle for a single connected user. It does not
+// know anything about the underlying transport (e.g. a WebSocket); callers
+// are expected to pump Send() into the real connection and feed incoming
+// frames back into the Hub via Subscribe/Publish.
+type Client struct {
+	ID   string
+	send chan []byte
+
+	topics map[string]struct{}
+}
+
+// Send returns the channel the Hub delivers messages for this client on.
+// It is closed by the Hub once the client is unregistered.
+func (c *Client) Send() <-chan []byte {
+	return c.send
+}
+
+type subscription struct {
+	client *Client
+	topic  string
+}
+
+type onlineQuery struct {
+	id   string
+	resp chan bool
+}
+
+// Hub owns client registration and topic subscriptions and is the only
+// goroutine allowed to mutate that state; everything else talks to it over
+// channels via Run.
+type Hub struct {
+	clients     map[string]*Client
+	subscribers map[string]map[*Client]struct{}
+
+	register    chan *Client
+	unregister  chan *Client
+	subscribe   chan subscription
+	unsubscribe chan subscription
+	broadcast   chan publication
+	online      chan onlineQuery
+}
+
+type publication struct {
+	topic   string
+	message []byte
+}
+
+// New returns an unstarted Hub; call Run to begin processing.
+func New() *Hub {
+	return &Hub{
+		clients:     make(map[string]*Client),
+		subscribers: make(map[string]map[*Client]struct{}),
+		register:    make(chan *Client),
+		unregister:  make(chan *Client),
+		subscribe:   make(chan subscription),
+		unsubscribe: make(chan subscription),
+		broadcast:   make(chan publication, 256),
+		online:      make(chan onlineQuery),
+	}
+}
+
+// Run processes registration, subscription, and broadcast events until ctx
+// is cancelled. It must be started in its own goroutine exactly once per
+// Hub.
+func (h *Hub) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case c := <-h.register:
+			h.clients[c.ID] = c
+			h.addSubscriber(PersonalTopic(c.ID), c)
+
+		case c := <-h.unregister:
+			if _, ok := h.clients[c.ID]; !ok {
+				continue
+			}
+			delete(h.clients, c.ID)
+			for topic := range c.topics {
+				h.removeSubscriber(topic, c)
+			}
+			close(c.send)
+
+		case s := <-h.subscribe:
+			h.addSubscriber(s.topic, s.client)
+
+		case s := <-h.unsubscribe:
+			h.removeSubscriber(s.topic, s.client)
+
+		case p := <-h.broadcast:
+			for c := range h.subscribers[p.topic] {
+				select {
+				case c.send <- p.message:
+				default:
+					// Slow consumer; drop rather than block the Hub.
+				}
+			}
+
+		case q := <-h.online:
+			_, ok := h.clients[q.id]
+			q.resp <- ok
+		}
+	}
+}
+
+func (h *Hub) addSubscriber(topic string, c *Client) {
+	if h.subscribers[topic] == nil {
+		h.subscribers[topic] = make(map[*Client]struct{})
+	}
+	h.subscribers[topic][c] = struct{}{}
+	c.topics[topic] = struct{}{}
+}
+
+func (h *Hub) removeSubscriber(topic string, c *Client) {
+	delete(h.subscribers[topic], c)
+	if len(h.subscribers[topic]) == 0 {
+		delete(h.subscribers, topic)
+	}
+	delete(c.topics, topic)
+}
+
+// NewClient returns a Client identified by id. It is not registered with
+// the Hub (and won't receive anything) until passed to Register.
+func (h *Hub) NewClient(id string) *Client {
+	return &Client{
+		ID:     id,
+		send:   make(chan []byte, 256),
+		topics: make(map[string]struct{}),
+	}
+}
+
+// Register admits c to the Hub and implicitly subscribes it to
+// PersonalTopic(c.ID), giving every user a personal topic for direct
+// messages.
+func (h *Hub) Register(c *Client) {
+	h.register <- c
+}
+
+// Unregister removes c from the Hub and every topic it was subscribed to,
+// closing its send channel.
+func (h *Hub) Unregister(c *Client) {
+	h.unregister <- c
+}
+
+// Subscribe adds c as a subscriber of topic; future Publish calls for that
+// topic are delivered to c.Send(). It returns ErrReservedTopic for a topic
+// in the personal-message namespace, which a client may not join by name.
+func (h *Hub) Subscribe(c *Client, topic string) error {
+	if isReserved(topic) {
+		return ErrReservedTopic
+	}
+	h.subscribe <- subscription{client: c, topic: topic}
+	return nil
+}
+
+// Unsubscribe removes c as a subscriber of topic.
+func (h *Hub) Unsubscribe(c *Client, topic string) {
+	h.unsubscribe <- subscription{client: c, topic: topic}
+}
+
+// Publish fans message out to every current subscriber of topic. A
+// subscriber whose send buffer is full is skipped rather than blocking the
+// Hub.
+func (h *Hub) Publish(topic string, message []byte) {
+	h.broadcast <- publication{topic: topic, message: message}
+}
+
+// IsOnline reports whether a client with the given ID is currently
+// registered.
+func (h *Hub) IsOnline(id string) bool {
+	resp := make(chan bool, 1)
+	h.online <- onlineQuery{id: id, resp: resp}
+	return <-resp
+}
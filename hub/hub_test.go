@@ -0,0 +1,122 @@
+package hub
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestHub(t *testing.T) *Hub {
+	t.Helper()
+	h := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go h.Run(ctx)
+	return h
+}
+
+func recv(t *testing.T, c *Client) []byte {
+	t.Helper()
+	select {
+	case msg, ok := <-c.Send():
+		if !ok {
+			t.Fatal("client was unregistered")
+		}
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+		return nil
+	}
+}
+
+func TestRegisterDeliversToPersonalTopic(t *testing.T) {
+	h := newTestHub(t)
+
+	alice := h.NewClient("alice")
+	h.Register(alice)
+
+	h.Publish(PersonalTopic("alice"), []byte("hello"))
+
+	if got := string(recv(t, alice)); got != "hello" {
+		t.Fatalf("got %q, want %q", got, "hello")
+	}
+}
+
+func TestSubscribeRejectsPersonalTopic(t *testing.T) {
+	h := newTestHub(t)
+
+	eve := h.NewClient("eve")
+	h.Register(eve)
+
+	if err := h.Subscribe(eve, PersonalTopic("victim")); err != ErrReservedTopic {
+		t.Fatalf("Subscribe(personal topic) returned %v, want %v", err, ErrReservedTopic)
+	}
+
+	h.Publish(PersonalTopic("victim"), []byte("dm for victim"))
+
+	select {
+	case msg := <-eve.Send():
+		t.Fatalf("eve received a message meant for victim: %q", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSubscribeAndPublishRoomTopic(t *testing.T) {
+	h := newTestHub(t)
+
+	alice := h.NewClient("alice")
+	bob := h.NewClient("bob")
+	h.Register(alice)
+	h.Register(bob)
+
+	if err := h.Subscribe(alice, "room:general"); err != nil {
+		t.Fatalf("Subscribe returned error: %v", err)
+	}
+	if err := h.Subscribe(bob, "room:general"); err != nil {
+		t.Fatalf("Subscribe returned error: %v", err)
+	}
+
+	h.Publish("room:general", []byte("hi all"))
+
+	if got := string(recv(t, alice)); got != "hi all" {
+		t.Fatalf("alice got %q, want %q", got, "hi all")
+	}
+	if got := string(recv(t, bob)); got != "hi all" {
+		t.Fatalf("bob got %q, want %q", got, "hi all")
+	}
+
+	h.Unsubscribe(bob, "room:general")
+	h.Publish("room:general", []byte("second"))
+
+	if got := string(recv(t, alice)); got != "second" {
+		t.Fatalf("alice got %q, want %q", got, "second")
+	}
+	select {
+	case msg := <-bob.Send():
+		t.Fatalf("bob received a message after unsubscribing: %q", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestIsOnlineAndUnregister(t *testing.T) {
+	h := newTestHub(t)
+
+	alice := h.NewClient("alice")
+	h.Register(alice)
+
+	if !h.IsOnline("alice") {
+		t.Fatal("IsOnline(alice) = false, want true")
+	}
+	if h.IsOnline("bob") {
+		t.Fatal("IsOnline(bob) = true, want false")
+	}
+
+	h.Unregister(alice)
+
+	if h.IsOnline("alice") {
+		t.Fatal("IsOnline(alice) = true after Unregister, want false")
+	}
+	if _, ok := <-alice.Send(); ok {
+		t.Fatal("alice.Send() still open after Unregister")
+	}
+}
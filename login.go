@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// storedUser is the MongoDB representation of a row in the users
+// collection checked by authenticate.
+type storedUser struct {
+	UserID       string `bson:"user_id"`
+	PasswordHash string `bson:"password_hash"`
+}
+
+// loginRequest is the JSON body accepted by handleLogin.
+type loginRequest struct {
+	UserID   string `json:"user_id"`
+	Password string `json:"password"`
+}
+
+// loginResponse is returned on successful authentication.
+type loginResponse struct {
+	Token string `json:"token"`
+}
+
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// authenticate checks password against the hash stored for userID in the
+// users collection.
+func authenticate(ctx context.Context, userID, password string) error {
+	var user storedUser
+	err := mongoClient.Database("chat").Collection("users").
+		FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
+	if err != nil {
+		return errInvalidCredentials
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return errInvalidCredentials
+	}
+	return nil
+}
+
+// handleLogin verifies credentials and, on success, returns a short-lived
+// JWT that authenticates subsequent calls to /ws.
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req loginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := authenticate(r.Context(), req.UserID, req.Password); err != nil {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := s.authIssuer.Issue(req.UserID)
+	if err != nil {
+		logger.Error("error issuing token", "user_id", req.UserID, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(loginResponse{Token: token}); err != nil {
+		logger.Error("error encoding login response", "user_id", req.UserID, "error", err)
+	}
+}
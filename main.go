@@ -2,19 +2,26 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"log"
 	"net/http"
-	"sync"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"nhooyr.io/websocket"
+
+	"github.com/es66han66/Real-Time-Chat-Application/auth"
+	"github.com/es66han66/Real-Time-Chat-Application/broker"
+	"github.com/es66han66/Real-Time-Chat-Application/hub"
 )
 
+// tokenTTL is how long a JWT issued by /login remains valid.
+const tokenTTL = time.Hour
+
 // Message struct to represent chat messages
 type Message struct {
 	Sender   string    `json:"sender" bson:"sender"`
@@ -23,10 +30,25 @@ type Message struct {
 	Time     time.Time `json:"time" bson:"time"`
 }
 
+// Frame is the wire format for anything a client sends over the
+// WebSocket. Type selects how it's interpreted:
+//   - "subscribe": join a topic to receive future "publish" frames for it
+//   - "publish": broadcast content to every subscriber of a topic
+//   - anything else (including "message" or unset): a direct 1:1 chat
+//     message, addressed by Receiver
+type Frame struct {
+	Type     string    `json:"type,omitempty"`
+	Sender   string    `json:"sender,omitempty"`
+	Receiver string    `json:"receiver,omitempty"`
+	Topic    string    `json:"topic,omitempty"`
+	Content  string    `json:"content,omitempty"`
+	Time     time.Time `json:"time,omitempty"`
+}
+
 var (
-	connections   map[string]*websocket.Conn // Map to store WebSocket connections for each user
-	messageQueues map[string][]Message       // Map to store message queues for each user
-	mongoClient   *mongo.Client              // MongoDB client instance
+	chatHub     *hub.Hub
+	mongoClient *mongo.Client // MongoDB client instance
+
 	requestsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "myapp_http_requests_total",
@@ -53,108 +75,65 @@ func main() {
 	// Establish MongoDB connection
 	ctx := context.Background()
 	connectMongo(ctx)
-	// Initialize connections map and message queues map
-	connections = make(map[string]*websocket.Conn)
-	messageQueues = make(map[string][]Message)
+	if err := ensurePendingIndexes(ctx); err != nil {
+		log.Fatal("Error creating pending message indexes:", err)
+	}
+	// Initialize the Hub
+	chatHub = hub.New()
+	go chatHub.Run(ctx)
+
+	server := NewServer(chatHub, newBroker(), newAuthIssuer(), allowedOrigins())
+
+	// Issue JWTs for authenticated users
+	http.HandleFunc("/login", server.handleLogin)
 	// Handle WebSocket connections
-	http.HandleFunc("/ws", handleWebSocket)
+	http.HandleFunc("/ws", server.handleWebSocket)
 	// Expose Prometheus metrics
 	http.Handle("/metrics", promhttp.Handler())
+	// k8s liveness/readiness probes
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
 	// Start HTTP server
-	log.Println("Server started on :8080")
+	logger.Info("server started", "addr", ":8080")
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
 
-func handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	requestsTotal.WithLabelValues(r.Method, "200").Inc()
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		ctx := r.Context()
-
-		conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-			InsecureSkipVerify: true,
-		})
-		if err != nil {
-			log.Println("Error accepting WebSocket:", err)
-			return
-		}
-		defer conn.Close(websocket.StatusInternalError, "Internal Server Error")
-
-		// Read user ID from request (e.g., from URL query parameter or header)
-		userID := r.URL.Query().Get("user_id")
-		if userID == "" {
-			log.Println("User ID not provided in request")
-			return
-		}
-		log.Println("User with ID ", userID, " connected")
-		// Add the new connection to the connections map
-		connections[userID] = conn
-		// Retrieve and send offline messages, if any
-		for _, msg := range messageQueues[userID] {
-			sendMessage(msg)
-		}
-		// Clear the message queue for the user
-		messageQueues[userID] = nil
-
-		// Handle disconnections and reconnections
-		for {
-			// Read message from client
-			_, msg, err := conn.Read(ctx)
-			if err != nil {
-				log.Println("Error reading message from client:", err)
-				break
-			}
-
-			// Unmarshal JSON message into Message struct
-			var message Message
-			err = json.Unmarshal(msg, &message)
-			if err != nil {
-				log.Println("Error unmarshaling JSON:", err)
-				continue
-			}
-
-			// Store message in MongoDB
-			err = saveMessage(message)
-			if err != nil {
-				log.Println("Error saving message to MongoDB:", err)
-			}
-
-			// Send message to recipient user
-			sendMessage(message)
-		}
-
-		// Remove the disconnected connection from the connections map
-		delete(connections, userID)
-	}()
-	wg.Wait()
+// newBroker returns a Redis-backed Broker if REDIS_ADDR is set, or a
+// no-op Local broker for single-instance deployments otherwise.
+func newBroker() broker.Broker {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		logger.Info("REDIS_ADDR not set, using local in-process broker")
+		return broker.Local{}
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	return broker.NewRedis(client)
 }
 
-func sendMessage(message Message) {
-	// Get recipient's WebSocket connection from the connections map
-	conn, ok := connections[message.Receiver]
-	if !ok {
-		// Recipient is offline, enqueue the message in their message queue
-		messageQueues[message.Receiver] = append(messageQueues[message.Receiver], message)
-		log.Printf("Recipient %s is not connected, message enqueued\n", message.Receiver)
-		return
+// newAuthIssuer builds the JWT issuer used to authenticate /ws connections
+// from the JWT_SECRET environment variable.
+func newAuthIssuer() *auth.Issuer {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		log.Fatal("JWT_SECRET must be set")
 	}
+	return auth.NewIssuer([]byte(secret), tokenTTL)
+}
 
-	// Marshal message to JSON
-	msgBytes, err := json.Marshal(message)
-	if err != nil {
-		log.Println("Error marshaling JSON:", err)
-		return
+// allowedOrigins returns the OriginPatterns allow-list for WebSocket
+// upgrades from the comma-separated ALLOWED_ORIGINS environment variable.
+func allowedOrigins() []string {
+	raw := os.Getenv("ALLOWED_ORIGINS")
+	if raw == "" {
+		return nil
 	}
 
-	// Send message to recipient's WebSocket connection
-	err = conn.Write(context.Background(), websocket.MessageText, msgBytes)
-	if err != nil {
-		log.Println("Error sending message to recipient:", err)
-		return
+	origins := strings.Split(raw, ",")
+	for i, origin := range origins {
+		origins[i] = strings.TrimSpace(origin)
 	}
-
-	log.Printf("Message sent to %s: %+v\n", message.Receiver, message)
+	return origins
 }
 
 func saveMessage(message Message) error {
@@ -167,6 +146,6 @@ func saveMessage(message Message) error {
 		return err
 	}
 
-	log.Printf("Saved message: %+v\n", message)
+	logger.Info("saved message", "sender", message.Sender, "receiver", message.Receiver)
 	return nil
 }
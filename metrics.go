@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+var (
+	activeConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "chat_active_connections",
+		Help: "Number of currently connected WebSocket clients.",
+	})
+	messagesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "chat_messages_total",
+			Help: "Total number of chat messages by direction and status.",
+		},
+		[]string{"direction", "status"},
+	)
+	messageDeliverySeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chat_message_delivery_seconds",
+		Help:    "Time from message persistence to successful delivery to the recipient.",
+		Buckets: prometheus.DefBuckets,
+	})
+	offlineQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "chat_offline_queue_depth",
+		Help: "Total number of undelivered messages queued across all users.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(activeConnections, messagesTotal, messageDeliverySeconds, offlineQueueDepth)
+}
+
+// newRequestID returns a short random identifier for correlating a single
+// connection's log lines across pods.
+func newRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// maxObservableDeliveryAge bounds how old a message's Time may be for
+// observeDeliverySeconds to record it. chat_message_delivery_seconds uses
+// Prometheus's default buckets (max ~10s) to track live-delivery latency;
+// a replayed offline message can be up to pendingRetention (7 days) old,
+// which would only ever land in the +Inf bucket and skew the
+// distribution without saying anything about delivery latency.
+const maxObservableDeliveryAge = time.Minute
+
+// observeDeliverySeconds records the time between a message's persisted
+// Time and now, if payload is a JSON-encoded Message carrying a recent
+// enough Time (publish/subscribe frames don't carry one and are ignored;
+// replayed offline messages are too old and are excluded).
+func observeDeliverySeconds(payload []byte) {
+	var timestamped struct {
+		Time time.Time `json:"time"`
+	}
+	if err := json.Unmarshal(payload, &timestamped); err != nil || timestamped.Time.IsZero() {
+		return
+	}
+	age := time.Since(timestamped.Time)
+	if age < 0 || age > maxObservableDeliveryAge {
+		return
+	}
+	messageDeliverySeconds.Observe(age.Seconds())
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// pendingRetention is how long an undelivered message is kept before the
+// MongoDB TTL index drops it.
+const pendingRetention = 7 * 24 * time.Hour
+
+// pendingMessage is the MongoDB representation of a message queued for a
+// recipient who was offline when it was sent.
+type pendingMessage struct {
+	Sender    string    `bson:"sender"`
+	Receiver  string    `bson:"receiver"`
+	Content   string    `bson:"content"`
+	Time      time.Time `bson:"time"`
+	Delivered bool      `bson:"delivered"`
+}
+
+func pendingCollection() *mongo.Collection {
+	return mongoClient.Database("chat").Collection("pending_messages")
+}
+
+// ensurePendingIndexes creates the indexes pendingCollection relies on: a
+// TTL index so undelivered messages older than pendingRetention are
+// dropped automatically, and a compound index so the replay query run on
+// every connect stays cheap.
+func ensurePendingIndexes(ctx context.Context) error {
+	_, err := pendingCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "time", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(pendingRetention.Seconds())),
+		},
+		{
+			Keys: bson.D{{Key: "receiver", Value: 1}, {Key: "delivered", Value: 1}, {Key: "time", Value: 1}},
+		},
+	})
+	return err
+}
+
+// enqueuePending stores message for later delivery to a currently-offline
+// recipient.
+func enqueuePending(ctx context.Context, message Message) error {
+	_, err := pendingCollection().InsertOne(ctx, pendingMessage{
+		Sender:   message.Sender,
+		Receiver: message.Receiver,
+		Content:  message.Content,
+		Time:     message.Time,
+	})
+	return err
+}
+
+// countAllPending returns how many undelivered messages are queued across
+// every user, for sampling the chat_offline_queue_depth gauge. It's kept
+// aggregate (rather than broken out per user) to avoid an unbounded
+// Prometheus series per user ID that was ever offline.
+func countAllPending(ctx context.Context) (int64, error) {
+	return pendingCollection().CountDocuments(ctx, bson.M{"delivered": false})
+}
+
+// deletePending removes a pending message once it has been delivered.
+func deletePending(ctx context.Context, message Message) error {
+	_, err := pendingCollection().DeleteOne(ctx, bson.M{
+		"sender":   message.Sender,
+		"receiver": message.Receiver,
+		"time":     message.Time,
+	})
+	return err
+}
+
+// replayPending streams every undelivered message queued for userID, in
+// send order, passing each to deliver. A message is removed from the queue
+// only once deliver returns successfully, so a client that disconnects
+// mid-replay will see the remaining messages again on its next connect.
+func replayPending(ctx context.Context, userID string, deliver func(Message) error) error {
+	cursor, err := pendingCollection().Find(ctx,
+		bson.M{"receiver": userID, "delivered": false},
+		options.Find().SetSort(bson.D{{Key: "time", Value: 1}}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var pending pendingMessage
+		if err := cursor.Decode(&pending); err != nil {
+			return err
+		}
+
+		message := Message{
+			Sender:   pending.Sender,
+			Receiver: pending.Receiver,
+			Content:  pending.Content,
+			Time:     pending.Time,
+		}
+
+		if err := deliver(message); err != nil {
+			return err
+		}
+		if err := deletePending(ctx, message); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	if depth, err := countAllPending(ctx); err == nil {
+		offlineQueueDepth.Set(float64(depth))
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"nhooyr.io/websocket"
+
+	"github.com/es66han66/Real-Time-Chat-Application/auth"
+	"github.com/es66han66/Real-Time-Chat-Application/broker"
+	"github.com/es66han66/Real-Time-Chat-Application/hub"
+)
+
+const (
+	// defaultPingPeriod is how often the server pings an idle client to
+	// keep NATs/load balancers from reaping the TCP connection.
+	defaultPingPeriod = 54 * time.Second
+	// defaultPongWait bounds how long the server waits for a client to
+	// answer a ping before giving up on the connection; ordinary reads
+	// have no deadline. Must be greater than defaultPingPeriod.
+	defaultPongWait = 60 * time.Second
+	// defaultWriteWait bounds how long a single write, including control
+	// frames, is allowed to take.
+	defaultWriteWait = 10 * time.Second
+	// defaultMaxMessageSize caps the size of a single incoming frame to
+	// protect against a misbehaving or malicious client.
+	defaultMaxMessageSize = 32 * 1024
+
+	// statusPingTimeout and statusWriteError are application-defined
+	// WebSocket close statuses (in the 4000-4999 private-use range)
+	// writePump closes conn with to unblock readPump's Read and report
+	// why, since it's the only goroutine that notices these failures.
+	statusPingTimeout websocket.StatusCode = 4000
+	statusWriteError  websocket.StatusCode = 4001
+)
+
+var disconnectsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "chat_disconnects_total",
+		Help: "Total number of WebSocket disconnects by reason.",
+	},
+	[]string{"reason"},
+)
+
+func init() {
+	prometheus.MustRegister(disconnectsTotal)
+}
+
+// Server holds the tunable connection timeouts and shared state for the
+// chat service.
+type Server struct {
+	hub            *hub.Hub
+	broker         broker.Broker
+	authIssuer     *auth.Issuer
+	allowedOrigins []string
+	pingPeriod     time.Duration
+	pongWait       time.Duration
+	writeWait      time.Duration
+	maxMessageSize int64
+}
+
+// NewServer returns a Server wired to h, b, and issuer, configured with the
+// package's default timeouts. origins is the OriginPatterns allow-list
+// applied to every WebSocket upgrade.
+func NewServer(h *hub.Hub, b broker.Broker, issuer *auth.Issuer, origins []string) *Server {
+	return &Server{
+		hub:            h,
+		broker:         b,
+		authIssuer:     issuer,
+		allowedOrigins: origins,
+		pingPeriod:     defaultPingPeriod,
+		pongWait:       defaultPongWait,
+		writeWait:      defaultWriteWait,
+		maxMessageSize: defaultMaxMessageSize,
+	}
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	protocol := r.Header.Get("Sec-WebSocket-Protocol")
+	if token == "" {
+		token = protocol
+	}
+
+	userID, err := s.authIssuer.Parse(token)
+	if err != nil {
+		requestsTotal.WithLabelValues(r.Method, "401").Inc()
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	requestsTotal.WithLabelValues(r.Method, "200").Inc()
+
+	acceptOptions := &websocket.AcceptOptions{OriginPatterns: s.allowedOrigins}
+	if protocol != "" {
+		acceptOptions.Subprotocols = []string{protocol}
+	}
+
+	conn, err := websocket.Accept(w, r, acceptOptions)
+	if err != nil {
+		logger.Error("error accepting WebSocket", "error", err)
+		return
+	}
+	conn.SetReadLimit(s.maxMessageSize)
+	defer conn.Close(websocket.StatusInternalError, "Internal Server Error")
+
+	connLogger := logger.With("request_id", newRequestID(), "user_id", userID)
+	connLogger.Info("user connected")
+
+	client := s.hub.NewClient(userID)
+	s.hub.Register(client)
+	activeConnections.Inc()
+	defer func() {
+		s.hub.Unregister(client)
+		activeConnections.Dec()
+	}()
+
+	// Relay any message another instance publishes for this user while
+	// they're connected here, so a sender on a different pod can still
+	// reach them.
+	brokerCtx, cancelBroker := context.WithCancel(context.Background())
+	defer cancelBroker()
+	go func() {
+		err := s.broker.Subscribe(brokerCtx, userID, func(payload []byte) {
+			s.hub.Publish(hub.PersonalTopic(client.ID), payload)
+
+			var message Message
+			if err := json.Unmarshal(payload, &message); err == nil {
+				if err := deletePending(context.Background(), message); err != nil {
+					connLogger.Error("error clearing delivered pending message", "error", err)
+				} else if depth, err := countAllPending(context.Background()); err == nil {
+					offlineQueueDepth.Set(float64(depth))
+				}
+			}
+		})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			connLogger.Error("broker subscription ended", "error", err)
+		}
+	}()
+
+	if err := replayPending(r.Context(), userID, func(message Message) error {
+		msgBytes, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		return s.write(conn, msgBytes)
+	}); err != nil {
+		connLogger.Error("error replaying offline messages", "error", err)
+	}
+
+	go s.writePump(conn, client, connLogger)
+	s.readPump(conn, client, connLogger)
+}
+
+func (s *Server) writePump(conn *websocket.Conn, client *hub.Client, connLogger *slog.Logger) {
+	ticker := time.NewTicker(s.pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, ok := <-client.Send():
+			if !ok {
+				return
+			}
+			if err := s.write(conn, msg); err != nil {
+				messagesTotal.WithLabelValues("sent", "failed").Inc()
+				connLogger.Error("error sending message", "error", err)
+				conn.Close(statusWriteError, "write error")
+				return
+			}
+			messagesTotal.WithLabelValues("sent", "success").Inc()
+			observeDeliverySeconds(msg)
+
+		case <-ticker.C:
+			// conn.Read in readPump never returns on its own for an idle
+			// peer (nhooyr handles pings/pongs internally and Read only
+			// wakes on data frames), so this ping is the only thing that
+			// detects a dead connection. Give the peer the full pongWait
+			// to answer, then actively close the conn on failure so
+			// readPump's blocked Read unblocks with an error.
+			ctx, cancel := context.WithTimeout(context.Background(), s.pongWait)
+			err := conn.Ping(ctx)
+			cancel()
+			if err != nil {
+				connLogger.Info("ping failed, closing connection", "error", err)
+				conn.Close(statusPingTimeout, "ping timeout")
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) write(conn *websocket.Conn, msg []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.writeWait)
+	defer cancel()
+	return conn.Write(ctx, websocket.MessageText, msg)
+}
+
+func (s *Server) readPump(conn *websocket.Conn, client *hub.Client, connLogger *slog.Logger) {
+	reason := "client_close"
+	defer func() {
+		disconnectsTotal.WithLabelValues(reason).Inc()
+	}()
+
+	for {
+		// No per-read deadline: a recipient who never sends anything is
+		// still a healthy connection. writePump's periodic conn.Ping is
+		// what detects a dead peer, closing conn (and unblocking this
+		// Read) if one stops answering. Deliberately not the literal
+		// per-read pongWait deadline chunk0-2 asked for — see 8b82cc5,
+		// which explains why that reaps idle-but-healthy recipients.
+		_, raw, err := conn.Read(context.Background())
+		if err != nil {
+			reason = disconnectReason(err)
+			connLogger.Info("connection closed", "reason", reason, "error", err)
+			return
+		}
+
+		var frame Frame
+		if err := json.Unmarshal(raw, &frame); err != nil {
+			messagesTotal.WithLabelValues("received", "failed").Inc()
+			connLogger.Error("error unmarshaling JSON", "error", err)
+			continue
+		}
+
+		switch frame.Type {
+		case "subscribe":
+			if err := s.hub.Subscribe(client, frame.Topic); err != nil {
+				connLogger.Warn("rejected subscribe", "topic", frame.Topic, "error", err)
+				continue
+			}
+
+		case "publish":
+			if hub.IsPersonalTopic(frame.Topic) {
+				connLogger.Warn("rejected publish to reserved topic", "topic", frame.Topic)
+				continue
+			}
+			payload, err := json.Marshal(frame)
+			if err != nil {
+				connLogger.Error("error marshaling publish frame", "error", err)
+				continue
+			}
+			s.hub.Publish(frame.Topic, payload)
+
+		default:
+			message := Message{
+				Sender:   client.ID,
+				Receiver: frame.Receiver,
+				Content:  frame.Content,
+				Time:     time.Now(),
+			}
+
+			if err := saveMessage(message); err != nil {
+				connLogger.Error("error saving message to MongoDB", "receiver", message.Receiver, "error", err)
+				messagesTotal.WithLabelValues("received", "failed").Inc()
+				continue
+			}
+			messagesTotal.WithLabelValues("received", "success").Inc()
+
+			s.sendMessage(message, connLogger)
+		}
+	}
+}
+
+// disconnectReason classifies a conn.Read error for the disconnectsTotal
+// metric.
+func disconnectReason(err error) string {
+	switch {
+	case websocket.CloseStatus(err) == statusPingTimeout:
+		return "ping_timeout"
+	case websocket.CloseStatus(err) == statusWriteError:
+		return "write_error"
+	case websocket.CloseStatus(err) == websocket.StatusNormalClosure,
+		websocket.CloseStatus(err) == websocket.StatusGoingAway:
+		return "client_close"
+	default:
+		return "read_error"
+	}
+}
+
+func (s *Server) sendMessage(message Message, connLogger *slog.Logger) {
+	msgBytes, err := json.Marshal(message)
+	if err != nil {
+		connLogger.Error("error marshaling JSON", "error", err)
+		return
+	}
+
+	if s.hub.IsOnline(message.Receiver) {
+		s.hub.Publish(hub.PersonalTopic(message.Receiver), msgBytes)
+		connLogger.Info("message sent", "receiver", message.Receiver)
+		return
+	}
+
+	// Not connected to this instance: another pod may have them, so try
+	// the broker first. Only fall back to the durable offline queue if
+	// the broker reports nobody was actually subscribed to receive it;
+	// otherwise the owning pod's delivery and this enqueue would race,
+	// risking a duplicate replay on the recipient's next connect.
+	delivered, err := s.broker.Publish(context.Background(), message.Receiver, msgBytes)
+	if err != nil {
+		connLogger.Error("error publishing to broker", "receiver", message.Receiver, "error", err)
+	}
+	if delivered {
+		connLogger.Info("message delivered via broker", "receiver", message.Receiver)
+		return
+	}
+
+	if err := enqueuePending(context.Background(), message); err != nil {
+		connLogger.Error("error enqueueing offline message", "receiver", message.Receiver, "error", err)
+	}
+	messagesTotal.WithLabelValues("sent", "enqueued").Inc()
+
+	if depth, err := countAllPending(context.Background()); err == nil {
+		offlineQueueDepth.Set(float64(depth))
+	}
+
+	connLogger.Info("recipient not connected locally, enqueued for offline delivery", "receiver", message.Receiver)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/es66han66/Real-Time-Chat-Application/auth"
+	"github.com/es66han66/Real-Time-Chat-Application/broker"
+	"github.com/es66han66/Real-Time-Chat-Application/hub"
+)
+
+func newTestServer() *Server {
+	issuer := auth.NewIssuer([]byte("test-secret"), time.Hour)
+	return NewServer(hub.New(), broker.Local{}, issuer, nil)
+}
+
+func TestHandleWebSocketRejectsBadTokens(t *testing.T) {
+	issuer := auth.NewIssuer([]byte("test-secret"), time.Hour)
+	expired := auth.NewIssuer([]byte("test-secret"), -time.Minute)
+
+	validToken, err := issuer.Issue("alice")
+	if err != nil {
+		t.Fatalf("Issue returned error: %v", err)
+	}
+	expiredToken, err := expired.Issue("alice")
+	if err != nil {
+		t.Fatalf("Issue returned error: %v", err)
+	}
+
+	// Replace the last signature character with one that decodes to
+	// different bits; base64url ignores its two low padding bits.
+	tamperedToken := validToken[:len(validToken)-1] + "A"
+	if strings.HasSuffix(validToken, "A") {
+		tamperedToken = validToken[:len(validToken)-1] + "Q"
+	}
+
+	cases := []struct {
+		name  string
+		token string
+	}{
+		{"missing token", ""},
+		{"malformed token", "not-a-jwt"},
+		{"tampered token", tamperedToken},
+		{"expired token", expiredToken},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer()
+
+			req := httptest.NewRequest(http.MethodGet, "/ws?token="+tc.token, nil)
+			rec := httptest.NewRecorder()
+
+			s.handleWebSocket(rec, req)
+
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+}